@@ -0,0 +1,116 @@
+// Copyright 2024 Sylvain Müller. All rights reserved.
+// Mount of this source code is governed by a MIT license that can be found
+// at https://github.com/tigerwill90/foxgeoip/blob/master/LICENSE.
+
+package foxgeoip
+
+import (
+	"container/list"
+	"net"
+	"net/netip"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// CacheStats reports the lookup cache's hit/miss counters. See IPFilter.Stats.
+type CacheStats struct {
+	Hits   uint64
+	Misses uint64
+}
+
+// lookupCache is a fixed-size LRU cache keyed by the 16-byte representation of the looked up IP, used by
+// WithLookupCache to skip the GeoIP database for addresses seen recently. Entries older than ttl are treated as
+// a miss and recomputed.
+type lookupCache struct {
+	mu     sync.Mutex
+	size   int
+	ttl    time.Duration
+	ll     *list.List
+	items  map[[16]byte]*list.Element
+	hits   atomic.Uint64
+	misses atomic.Uint64
+}
+
+type cacheEntry struct {
+	key      [16]byte
+	decision Decision
+	expiry   time.Time
+}
+
+func newLookupCache(size int, ttl time.Duration) *lookupCache {
+	return &lookupCache{
+		size:  size,
+		ttl:   ttl,
+		ll:    list.New(),
+		items: make(map[[16]byte]*list.Element, size),
+	}
+}
+
+func (c *lookupCache) get(ip net.IP) (Decision, bool) {
+	key, ok := cacheKey(ip)
+	if !ok {
+		return Decision{}, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.items[key]
+	if !ok {
+		c.misses.Add(1)
+		return Decision{}, false
+	}
+
+	entry := elem.Value.(*cacheEntry)
+	if time.Now().After(entry.expiry) {
+		c.ll.Remove(elem)
+		delete(c.items, key)
+		c.misses.Add(1)
+		return Decision{}, false
+	}
+
+	c.ll.MoveToFront(elem)
+	c.hits.Add(1)
+	return entry.decision, true
+}
+
+func (c *lookupCache) set(ip net.IP, decision Decision) {
+	key, ok := cacheKey(ip)
+	if !ok {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.items[key]; ok {
+		elem.Value.(*cacheEntry).decision = decision
+		elem.Value.(*cacheEntry).expiry = time.Now().Add(c.ttl)
+		c.ll.MoveToFront(elem)
+		return
+	}
+
+	elem := c.ll.PushFront(&cacheEntry{key: key, decision: decision, expiry: time.Now().Add(c.ttl)})
+	c.items[key] = elem
+
+	if c.ll.Len() > c.size {
+		oldest := c.ll.Back()
+		if oldest != nil {
+			c.ll.Remove(oldest)
+			delete(c.items, oldest.Value.(*cacheEntry).key)
+		}
+	}
+}
+
+func (c *lookupCache) stats() CacheStats {
+	return CacheStats{Hits: c.hits.Load(), Misses: c.misses.Load()}
+}
+
+func cacheKey(ip net.IP) ([16]byte, bool) {
+	addr, ok := netip.AddrFromSlice(ip.To16())
+	if !ok {
+		return [16]byte{}, false
+	}
+	return addr.As16(), true
+}
@@ -0,0 +1,55 @@
+// Copyright 2024 Sylvain Müller. All rights reserved.
+// Mount of this source code is governed by a MIT license that can be found
+// at https://github.com/tigerwill90/foxgeoip/blob/master/LICENSE.
+
+package foxgeoip
+
+import (
+	"github.com/oschwald/geoip2-golang"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestLookupCacheHitsAndExpiry(t *testing.T) {
+	r, err := geoip2.Open("testdata/GeoLite2-Country-outdated.mmdb")
+	require.NoError(t, err)
+
+	f := New(r, WithBlacklistedCountries("US"), WithLookupCache(8, 50*time.Millisecond))
+
+	decision, err := f.Allowed(net.ParseIP(egUS))
+	require.NoError(t, err)
+	assert.False(t, decision.Allowed)
+
+	decision, err = f.Allowed(net.ParseIP(egUS))
+	require.NoError(t, err)
+	assert.False(t, decision.Allowed)
+
+	stats := f.Stats()
+	assert.EqualValues(t, 1, stats.Hits)
+	assert.EqualValues(t, 1, stats.Misses)
+
+	time.Sleep(100 * time.Millisecond)
+
+	_, err = f.Allowed(net.ParseIP(egUS))
+	require.NoError(t, err)
+
+	stats = f.Stats()
+	assert.EqualValues(t, 1, stats.Hits)
+	assert.EqualValues(t, 2, stats.Misses)
+}
+
+func TestLookupCacheEviction(t *testing.T) {
+	cache := newLookupCache(1, time.Minute)
+	cache.set(net.ParseIP(egUS), Decision{Allowed: true})
+	cache.set(net.ParseIP(egAU), Decision{Allowed: false})
+
+	_, ok := cache.get(net.ParseIP(egUS))
+	assert.False(t, ok, "oldest entry should have been evicted")
+
+	decision, ok := cache.get(net.ParseIP(egAU))
+	assert.True(t, ok)
+	assert.False(t, decision.Allowed)
+}
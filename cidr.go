@@ -0,0 +1,90 @@
+// Copyright 2024 Sylvain Müller. All rights reserved.
+// Mount of this source code is governed by a MIT license that can be found
+// at https://github.com/tigerwill90/foxgeoip/blob/master/LICENSE.
+
+package foxgeoip
+
+import "net"
+
+// privateCIDRs are the ranges considered private by WithAllowPrivate: RFC 1918/4193 private networks, loopback,
+// and link-local, for both IPv4 and IPv6.
+var privateCIDRs = []string{
+	"10.0.0.0/8",
+	"172.16.0.0/12",
+	"192.168.0.0/16",
+	"127.0.0.0/8",
+	"169.254.0.0/16",
+	"::1/128",
+	"fc00::/7",
+	"fe80::/10",
+}
+
+// cidrTrie is a binary trie over IP prefixes. Membership tests run in time proportional to the address length
+// (32 or 128 bits) regardless of how many CIDRs are loaded, so WithAllowedIPBlocks/WithBlockedIPBlocks stay cheap
+// even with thousands of entries.
+type cidrTrie struct {
+	root trieNode
+}
+
+type trieNode struct {
+	children [2]*trieNode
+	leaf     bool
+}
+
+// newCIDRTrie builds a cidrTrie from the given CIDRs, silently skipping entries that fail to parse.
+func newCIDRTrie(cidrs []string) *cidrTrie {
+	t := &cidrTrie{}
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			continue
+		}
+		t.insert(network)
+	}
+	return t
+}
+
+func (t *cidrTrie) insert(network *net.IPNet) {
+	ip := network.IP.To16()
+	ones, bits := network.Mask.Size()
+	if bits == 32 {
+		ones += 96
+	}
+
+	node := &t.root
+	for i := 0; i < ones; i++ {
+		bit := bitAt(ip, i)
+		if node.children[bit] == nil {
+			node.children[bit] = &trieNode{}
+		}
+		node = node.children[bit]
+	}
+	node.leaf = true
+}
+
+// contains reports whether ip falls within any of the trie's prefixes.
+func (t *cidrTrie) contains(ip net.IP) bool {
+	addr := ip.To16()
+	if addr == nil {
+		return false
+	}
+
+	node := &t.root
+	if node.leaf {
+		return true
+	}
+	for i := 0; i < 128; i++ {
+		node = node.children[bitAt(addr, i)]
+		if node == nil {
+			return false
+		}
+		if node.leaf {
+			return true
+		}
+	}
+	return false
+}
+
+func bitAt(ip net.IP, i int) int {
+	return int((ip[i/8] >> (7 - uint(i%8))) & 1)
+}
@@ -0,0 +1,56 @@
+// Copyright 2024 Sylvain Müller. All rights reserved.
+// Mount of this source code is governed by a MIT license that can be found
+// at https://github.com/tigerwill90/foxgeoip/blob/master/LICENSE.
+
+package foxgeoip
+
+import (
+	"github.com/oschwald/geoip2-golang"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net"
+	"testing"
+)
+
+func TestCIDRTrie(t *testing.T) {
+	trie := newCIDRTrie([]string{"192.168.1.0/24", "10.0.0.0/8", "2001:db8::/32", "not-a-cidr"})
+
+	cases := []struct {
+		name string
+		ip   string
+		want bool
+	}{
+		{name: "in first ipv4 block", ip: "192.168.1.42", want: true},
+		{name: "not in any ipv4 block", ip: "192.168.2.1", want: false},
+		{name: "in second ipv4 block", ip: "10.1.2.3", want: true},
+		{name: "in ipv6 block", ip: "2001:db8::1", want: true},
+		{name: "not in ipv6 block", ip: "2001:db9::1", want: false},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.want, trie.contains(net.ParseIP(tc.ip)))
+		})
+	}
+}
+
+func TestAllowedAndBlockedIPBlocksBypassLookup(t *testing.T) {
+	r, err := geoip2.Open("testdata/GeoLite2-Country-outdated.mmdb")
+	require.NoError(t, err)
+
+	f := New(r,
+		WithBlacklistedCountries("US"),
+		WithAllowedIPBlocks("52.92.0.0/16"),
+		WithBlockedIPBlocks("49.189.0.0/16"),
+	)
+
+	decision, err := f.Allowed(net.ParseIP(egUS))
+	require.NoError(t, err)
+	assert.True(t, decision.Allowed)
+	assert.Equal(t, "allowed IP block", decision.Reason)
+
+	decision, err = f.Allowed(net.ParseIP(egAU))
+	require.NoError(t, err)
+	assert.False(t, decision.Allowed)
+	assert.Equal(t, "blocked IP block", decision.Reason)
+}
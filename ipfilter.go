@@ -14,27 +14,58 @@ import (
 	"strings"
 )
 
+// DatabaseReader is the subset of *geoip2.Reader used by foxgeoip to resolve a Location. It is implemented by
+// *geoip2.Reader itself as well as by *ReloadableReader, so either can be passed to New, Middleware, and the
+// WithCityDatabase/WithASNDatabase/WithAnonymousIPDatabase options.
+type DatabaseReader interface {
+	City(ip net.IP) (*geoip2.City, error)
+	Country(ip net.IP) (*geoip2.Country, error)
+	ASN(ip net.IP) (*geoip2.ASN, error)
+	AnonymousIP(ip net.IP) (*geoip2.AnonymousIP, error)
+}
+
 type IPFilter struct {
 	strategy     fox.ClientIPStrategy
-	r            *geoip2.Reader
+	r            DatabaseReader
 	cfg          *config
 	blockHandler fox.HandlerFunc
 	countryCodes countryCodes
 	logger       *slog.Logger
 	isWhitelist  bool
+	allowedTrie  *cidrTrie
+	blockedTrie  *cidrTrie
+	privateTrie  *cidrTrie
+	cache        *lookupCache
+	rateLimiters *rateLimiters
 }
 
 // New creates a new IPFilter with the provided GeoIP2 reader and options. The ip filter is intended to work with
-// MaxMind GeoLite2 or GeoIP2 databases. It should work with other MMDB databases but has not been tested.
+// MaxMind GeoLite2 or GeoIP2 databases. It should work with other MMDB databases but has not been tested. The
+// reader may be a Country or a City database, and a *ReloadableReader can be passed in place of a plain
+// *geoip2.Reader to support zero-downtime database refresh. Use WithASNDatabase and WithAnonymousIPDatabase to
+// combine it with ASN and anonymizing-service rules. Use WithPolicy for actions beyond plain allow/deny, such as
+// challenging, redirecting, tagging, or rate limiting matching traffic.
 // Note that blacklist and whitelist options are mutually exclusive. Either it is a whitelist, and all requests are
 // denied except for IPs that have a country code associated in the whitelist, OR it is a blacklist, and all requests are
 // allowed except IPs that have a country code associated in the blacklist.
-func New(db *geoip2.Reader, opts ...Option) *IPFilter {
+func New(db DatabaseReader, opts ...Option) *IPFilter {
 	cfg := defaultConfig()
 	for _, opt := range opts {
 		opt.apply(cfg)
 	}
 
+	var builtin []namedRule
+	if len(cfg.blacklistedASNs) > 0 {
+		builtin = append(builtin, blacklistedASNsRule(cfg.blacklistedASNs))
+	}
+	if cfg.blockAnonymous {
+		builtin = append(builtin, blockAnonymousRule())
+	}
+	if cfg.blockTorExit {
+		builtin = append(builtin, blockTorExitRule())
+	}
+	cfg.rules = append(builtin, cfg.rules...)
+
 	f := &IPFilter{
 		r:            db,
 		cfg:          cfg,
@@ -43,6 +74,22 @@ func New(db *geoip2.Reader, opts ...Option) *IPFilter {
 		logger:       slog.New(cfg.handler),
 	}
 
+	if len(cfg.allowedIPBlocks) > 0 {
+		f.allowedTrie = newCIDRTrie(cfg.allowedIPBlocks)
+	}
+	if len(cfg.blockedIPBlocks) > 0 {
+		f.blockedTrie = newCIDRTrie(cfg.blockedIPBlocks)
+	}
+	if cfg.allowPrivate {
+		f.privateTrie = newCIDRTrie(privateCIDRs)
+	}
+	if cfg.cacheSize > 0 {
+		f.cache = newLookupCache(cfg.cacheSize, cfg.cacheTTL)
+	}
+	if len(cfg.policy) > 0 {
+		f.rateLimiters = newRateLimiters()
+	}
+
 	whitelist := normalizeCodes(cfg.whitelist)
 	if len(whitelist) > 0 {
 		f.isWhitelist = true
@@ -59,7 +106,7 @@ func New(db *geoip2.Reader, opts ...Option) *IPFilter {
 // Note that blacklist and whitelist options are mutually exclusive. Either it is a whitelist, and all requests are
 // denied except for IPs that have a country code associated in the whitelist, OR it is a blacklist, and all requests are
 // allowed except IPs that have a country code associated in the blacklist.
-func Middleware(db *geoip2.Reader, opts ...Option) fox.MiddlewareFunc {
+func Middleware(db DatabaseReader, opts ...Option) fox.MiddlewareFunc {
 	return New(db, opts...).FilterIP
 }
 
@@ -79,7 +126,17 @@ func (f *IPFilter) FilterIP(next fox.HandlerFunc) fox.HandlerFunc {
 
 		var ipAddr *net.IPAddr
 		var err error
-		if f.strategy == nil {
+		if len(f.cfg.trustedProxies) > 0 && !f.peerTrusted(c.Request()) {
+			f.logger.DebugContext(ctx, "geoip: untrusted peer, ignoring forwarded headers")
+			f.stripForwardedHeaders(c.Request())
+			peerIP := remoteAddrIP(c.Request().RemoteAddr)
+			if peerIP == nil {
+				f.logger.ErrorContext(ctx, "geoip: failed to derive client ip from remote addr", slog.String("remote_addr", c.Request().RemoteAddr))
+				http.Error(c.Writer(), http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				return
+			}
+			ipAddr = &net.IPAddr{IP: peerIP}
+		} else if f.strategy == nil {
 			ipAddr, err = c.ClientIP()
 		} else {
 			ipAddr, err = f.strategy.ClientIP(c)
@@ -91,30 +148,82 @@ func (f *IPFilter) FilterIP(next fox.HandlerFunc) fox.HandlerFunc {
 			return
 		}
 
-		allowed, code, err := f.Allowed(ipAddr.IP)
+		decision, err := f.Allowed(ipAddr.IP)
 		if err != nil {
 			f.logger.ErrorContext(
 				ctx,
 				"geoip: unexpected lookup error",
 				slog.String("ip", ipAddr.String()),
-				slog.String("country", code),
 				slog.String("error", err.Error()),
 			)
 			http.Error(c.Writer(), http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 			return
 		}
 
-		if !allowed {
+		if f.cfg.stashLocation && !decision.bypassed {
+			loc := decision.Location
+			c.SetRequest(c.Request().WithContext(context.WithValue(ctx, locationContextKey{}, &loc)))
+		}
+
+		if f.cfg.countryHeader != "" {
+			c.Writer().Header().Set(f.cfg.countryHeader, decision.Location.CountryISO)
+		}
+		if f.cfg.decisionHeader != "" {
+			c.Writer().Header().Set(f.cfg.decisionHeader, decision.headerValue())
+		}
+
+		if !decision.Allowed {
 			f.logger.WarnContext(
 				ctx,
 				"geoip: blocking ip address",
 				slog.String("ip", ipAddr.String()),
-				slog.String("country", code),
+				slog.String("country", decision.Location.CountryISO),
+				slog.String("reason", decision.Reason),
 			)
 			f.blockHandler(c)
 			return
 		}
 
+		if len(f.cfg.policy) > 0 && !decision.bypassed {
+			f.applyAction(c, next, f.evaluatePolicy(decision.Location), ipAddr)
+			return
+		}
+
+		next(c)
+	}
+}
+
+// applyAction dispatches a request according to action, the outcome of evaluatePolicy. ActionDeny, an
+// exhausted ActionRateLimit, and an ActionChallenge with no handler configured all fall back to the filter's
+// configured WithResponse handler, mirroring a country blacklist/whitelist denial.
+func (f *IPFilter) applyAction(c fox.Context, next fox.HandlerFunc, action Action, ipAddr *net.IPAddr) {
+	ctx := c.Request().Context()
+
+	switch action.kind {
+	case actionDeny:
+		f.logger.WarnContext(ctx, "geoip: policy denied request", slog.String("ip", ipAddr.String()))
+		f.blockHandler(c)
+	case actionChallenge:
+		if action.handler == nil {
+			f.logger.WarnContext(ctx, "geoip: policy challenge has no handler, denying request", slog.String("ip", ipAddr.String()))
+			f.blockHandler(c)
+			return
+		}
+		f.logger.InfoContext(ctx, "geoip: policy challenged request", slog.String("ip", ipAddr.String()))
+		action.handler(c)
+	case actionRedirect:
+		http.Redirect(c.Writer(), c.Request(), action.url, http.StatusFound)
+	case actionTag:
+		c.Writer().Header().Set(action.header, action.value)
+		next(c)
+	case actionRateLimit:
+		if !f.rateLimiters.allow(action.key, action.rps) {
+			f.logger.WarnContext(ctx, "geoip: policy rate limited request", slog.String("ip", ipAddr.String()), slog.String("key", action.key))
+			f.blockHandler(c)
+			return
+		}
+		next(c)
+	default:
 		next(c)
 	}
 }
@@ -125,28 +234,132 @@ func DefaultBlockingResponse(c fox.Context) {
 	c.Writer().WriteHeader(http.StatusForbidden)
 }
 
-// Allowed checks if the given IP address is allowed based on the filter's configuration.
-func (f *IPFilter) Allowed(ip net.IP) (allowed bool, code string, err error) {
-	allowed, code, err = f.allowed(f.countryCodes, ip)
+// Allowed resolves the Location of the given IP address and evaluates it against the filter's configuration
+// (CIDR overrides, country blacklist/whitelist, blacklisted ASNs, and rules), returning a Decision describing
+// the outcome. WithAllowedIPBlocks, WithBlockedIPBlocks and WithAllowPrivate are evaluated first and, on a match,
+// short-circuit without ever querying the GeoIP database.
+func (f *IPFilter) Allowed(ip net.IP) (Decision, error) {
+	if f.allowedTrie != nil && f.allowedTrie.contains(ip) {
+		return Decision{Allowed: true, Reason: "allowed IP block", bypassed: true}, nil
+	}
+	if f.blockedTrie != nil && f.blockedTrie.contains(ip) {
+		return Decision{Reason: "blocked IP block", bypassed: true}, nil
+	}
+	if f.privateTrie != nil && f.privateTrie.contains(ip) {
+		return Decision{Allowed: true, Reason: "private address", bypassed: true}, nil
+	}
+
+	if f.cache != nil {
+		if decision, ok := f.cache.get(ip); ok {
+			return decision, nil
+		}
+	}
+
+	decision, err := f.lookup(ip)
 	if err != nil {
-		return
+		return Decision{}, err
+	}
+
+	if f.cache != nil {
+		f.cache.set(ip, decision)
 	}
-	return allowed == f.isWhitelist, code, nil
+
+	return decision, nil
 }
 
-func (f *IPFilter) allowed(codes countryCodes, ip net.IP) (allowed bool, code string, err error) {
-	country, err := f.r.Country(ip)
+// lookup resolves ip's Location from the configured databases and evaluates it against the country
+// blacklist/whitelist, blacklisted ASNs, and rules. It is the expensive, database-querying path that
+// WithLookupCache caches the result of.
+func (f *IPFilter) lookup(ip net.IP) (Decision, error) {
+	loc, err := f.resolveLocation(ip)
 	if err != nil {
-		return false, "", err
+		return Decision{}, err
+	}
+
+	for _, rule := range f.cfg.rules {
+		if rule.match(loc) {
+			return Decision{Location: loc, Reason: rule.reason}, nil
+		}
 	}
 
-	code = country.Country.IsoCode
-	// Default to not in the list
-	if len(code) == 0 {
-		return
+	inList := f.countryCodes.has(loc.CountryISO)
+	allowed := inList == f.isWhitelist
+
+	reason := "country not in blacklist"
+	switch {
+	case f.isWhitelist && allowed:
+		reason = "country in whitelist"
+	case f.isWhitelist && !allowed:
+		reason = "country not in whitelist"
+	case !f.isWhitelist && !allowed:
+		reason = "country in blacklist"
+	}
+
+	return Decision{Allowed: allowed, Location: loc, Reason: reason}, nil
+}
+
+// Stats returns the lookup cache's hit/miss counters. It returns the zero value if WithLookupCache was not
+// configured.
+func (f *IPFilter) Stats() CacheStats {
+	if f.cache == nil {
+		return CacheStats{}
+	}
+	return f.cache.stats()
+}
+
+// resolveLocation looks up ip against the configured databases and assembles a Location. The reader passed to
+// New may be a Country or a City database: City is attempted first (a Country database also answers City lookups,
+// just without city/subdivision data) and Country is used as a fallback for databases that don't support City.
+func (f *IPFilter) resolveLocation(ip net.IP) (Location, error) {
+	var loc Location
+
+	cityReader := f.cfg.cityDB
+	if cityReader == nil {
+		cityReader = f.r
+	}
+
+	city, err := cityReader.City(ip)
+	switch {
+	case err == nil:
+		loc.CountryISO = city.Country.IsoCode
+		loc.City = city.City.Names["en"]
+		for _, subdivision := range city.Subdivisions {
+			loc.Subdivisions = append(loc.Subdivisions, subdivision.IsoCode)
+		}
+	case isInvalidMethodError(err):
+		country, cErr := f.r.Country(ip)
+		if cErr != nil {
+			return loc, cErr
+		}
+		loc.CountryISO = country.Country.IsoCode
+	default:
+		return loc, err
+	}
+
+	if f.cfg.asnDB != nil {
+		asn, err := f.cfg.asnDB.ASN(ip)
+		if err != nil && !isInvalidMethodError(err) {
+			return loc, err
+		}
+		if err == nil {
+			loc.ASN = asn.AutonomousSystemNumber
+			loc.Org = asn.AutonomousSystemOrganization
+		}
+	}
+
+	if f.cfg.anonymousIPDB != nil {
+		anon, err := f.cfg.anonymousIPDB.AnonymousIP(ip)
+		if err != nil && !isInvalidMethodError(err) {
+			return loc, err
+		}
+		if err == nil {
+			loc.IsAnonymous = anon.IsAnonymous
+			loc.IsHostingProvider = anon.IsHostingProvider
+			loc.IsTorExit = anon.IsTorExitNode
+		}
 	}
 
-	return codes.has(code), code, nil
+	return loc, nil
 }
 
 type countryCodes map[string]struct{}
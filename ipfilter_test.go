@@ -5,6 +5,7 @@
 package foxgeoip
 
 import (
+	"errors"
 	"github.com/oschwald/geoip2-golang"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -98,10 +99,10 @@ func TestAllowed(t *testing.T) {
 	for _, tc := range cases {
 		t.Run(tc.name, func(t *testing.T) {
 			ipfilter := New(r, tc.opts...)
-			allowed, code, err := ipfilter.Allowed(tc.ip)
+			decision, err := ipfilter.Allowed(tc.ip)
 			require.NoError(t, err)
-			assert.Equal(t, tc.want, allowed)
-			assert.Equal(t, tc.wantCode, code)
+			assert.Equal(t, tc.want, decision.Allowed)
+			assert.Equal(t, tc.wantCode, decision.Location.CountryISO)
 		})
 	}
 }
@@ -260,3 +261,285 @@ func TestMiddleware(t *testing.T) {
 		})
 	}
 }
+
+func TestCountryAndDecisionHeaders(t *testing.T) {
+	r, err := geoip2.Open("testdata/GeoLite2-Country-outdated.mmdb")
+	require.NoError(t, err)
+
+	var gotLocation *Location
+	f := fox.New(
+		fox.WithClientIPStrategy(strategy.NewRemoteAddr()),
+		fox.WithMiddleware(
+			Middleware(
+				r,
+				WithBlacklistedCountries("US"),
+				WithCountryHeader("X-IPCountry"),
+				WithDecisionHeader("X-IPDecision"),
+				WithStashLocation(true),
+			),
+		),
+	)
+	f.MustHandle(http.MethodGet, "/foobar", func(c fox.Context) {
+		gotLocation, _ = FromContext(c.Request().Context())
+		c.Writer().WriteHeader(http.StatusNoContent)
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/foobar", nil)
+	req.RemoteAddr = egAU
+	w := httptest.NewRecorder()
+	f.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Equal(t, "AU", w.Header().Get("X-IPCountry"))
+	assert.Equal(t, "allow", w.Header().Get("X-IPDecision"))
+	require.NotNil(t, gotLocation)
+	assert.Equal(t, "AU", gotLocation.CountryISO)
+
+	req = httptest.NewRequest(http.MethodGet, "/foobar", nil)
+	req.RemoteAddr = egUS
+	w = httptest.NewRecorder()
+	f.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Equal(t, "US", w.Header().Get("X-IPCountry"))
+	assert.Equal(t, "deny", w.Header().Get("X-IPDecision"))
+}
+
+// stubReader is a minimal DatabaseReader used to exercise the ASN, Anonymous-IP, City and rule-evaluation paths
+// of IPFilter without requiring real ASN/Anonymous-IP/City MMDB fixtures, since testdata only ships a Country
+// database. A nil func for a given method reports it as unsupported, the same way a Country database answers
+// ASN/AnonymousIP calls.
+type stubReader struct {
+	cityFn        func(net.IP) (*geoip2.City, error)
+	countryFn     func(net.IP) (*geoip2.Country, error)
+	asnFn         func(net.IP) (*geoip2.ASN, error)
+	anonymousIPFn func(net.IP) (*geoip2.AnonymousIP, error)
+}
+
+func (s stubReader) City(ip net.IP) (*geoip2.City, error) {
+	if s.cityFn == nil {
+		return nil, geoip2.InvalidMethodError{Method: "City", DatabaseType: "Country"}
+	}
+	return s.cityFn(ip)
+}
+
+func (s stubReader) Country(ip net.IP) (*geoip2.Country, error) {
+	if s.countryFn == nil {
+		return nil, geoip2.InvalidMethodError{Method: "Country", DatabaseType: "stub"}
+	}
+	return s.countryFn(ip)
+}
+
+func (s stubReader) ASN(ip net.IP) (*geoip2.ASN, error) {
+	if s.asnFn == nil {
+		return nil, geoip2.InvalidMethodError{Method: "ASN", DatabaseType: "Country"}
+	}
+	return s.asnFn(ip)
+}
+
+func (s stubReader) AnonymousIP(ip net.IP) (*geoip2.AnonymousIP, error) {
+	if s.anonymousIPFn == nil {
+		return nil, geoip2.InvalidMethodError{Method: "AnonymousIP", DatabaseType: "Country"}
+	}
+	return s.anonymousIPFn(ip)
+}
+
+func TestResolveLocationWithASNAndAnonymousIP(t *testing.T) {
+	country := &geoip2.Country{}
+	country.Country.IsoCode = "US"
+
+	base := stubReader{countryFn: func(net.IP) (*geoip2.Country, error) { return country, nil }}
+	asnDB := stubReader{asnFn: func(net.IP) (*geoip2.ASN, error) {
+		return &geoip2.ASN{AutonomousSystemNumber: 64512, AutonomousSystemOrganization: "Example Org"}, nil
+	}}
+	anonDB := stubReader{anonymousIPFn: func(net.IP) (*geoip2.AnonymousIP, error) {
+		return &geoip2.AnonymousIP{IsAnonymous: true, IsHostingProvider: true, IsTorExitNode: true}, nil
+	}}
+
+	f := New(base, WithASNDatabase(asnDB), WithAnonymousIPDatabase(anonDB))
+
+	loc, err := f.resolveLocation(net.ParseIP(egUS))
+	require.NoError(t, err)
+	assert.Equal(t, "US", loc.CountryISO)
+	assert.EqualValues(t, 64512, loc.ASN)
+	assert.Equal(t, "Example Org", loc.Org)
+	assert.True(t, loc.IsAnonymous)
+	assert.True(t, loc.IsHostingProvider)
+	assert.True(t, loc.IsTorExit)
+}
+
+func TestCityDatabaseOverride(t *testing.T) {
+	city := &geoip2.City{}
+	city.Country.IsoCode = "US"
+	city.City.Names = map[string]string{"en": "Seattle"}
+	city.Subdivisions = []struct {
+		Names     map[string]string `maxminddb:"names"`
+		IsoCode   string            `maxminddb:"iso_code"`
+		GeoNameID uint              `maxminddb:"geoname_id"`
+	}{
+		{IsoCode: "WA"},
+	}
+
+	base := stubReader{countryFn: func(net.IP) (*geoip2.Country, error) {
+		t.Fatal("base Country should not be called once WithCityDatabase resolves the location")
+		return nil, nil
+	}}
+	cityDB := stubReader{cityFn: func(net.IP) (*geoip2.City, error) { return city, nil }}
+
+	f := New(base, WithCityDatabase(cityDB))
+
+	loc, err := f.resolveLocation(net.ParseIP(egUS))
+	require.NoError(t, err)
+	assert.Equal(t, "US", loc.CountryISO)
+	assert.Equal(t, "Seattle", loc.City)
+	assert.Equal(t, []string{"WA"}, loc.Subdivisions)
+}
+
+func TestBuiltinAndCustomRules(t *testing.T) {
+	country := &geoip2.Country{}
+	country.Country.IsoCode = "US"
+	base := stubReader{countryFn: func(net.IP) (*geoip2.Country, error) { return country, nil }}
+
+	cases := []struct {
+		name       string
+		opts       []Option
+		asn        uint
+		anonymous  bool
+		torExit    bool
+		wantAllow  bool
+		wantReason string
+	}{
+		{
+			name:       "blacklisted ASN denies regardless of country",
+			opts:       []Option{WithBlacklistedASNs(64512)},
+			asn:        64512,
+			wantReason: "blacklisted ASN",
+		},
+		{
+			name:       "non-blacklisted ASN allows",
+			opts:       []Option{WithBlacklistedASNs(64512)},
+			asn:        64513,
+			wantAllow:  true,
+			wantReason: "country not in blacklist",
+		},
+		{
+			name:       "block anonymous denies",
+			opts:       []Option{WithBlockAnonymous(true)},
+			anonymous:  true,
+			wantReason: "anonymous IP",
+		},
+		{
+			name:       "block tor exit denies",
+			opts:       []Option{WithBlockTorExit(true)},
+			torExit:    true,
+			wantReason: "tor exit node",
+		},
+		{
+			name: "custom rule denies and takes precedence over the country blacklist",
+			opts: []Option{
+				WithBlacklistedCountries("FR"),
+				WithRule(func(loc Location) bool { return loc.CountryISO == "US" }),
+			},
+			wantReason: "custom rule matched",
+		},
+		{
+			name: "nil custom rule is ignored",
+			opts: []Option{
+				WithRule(nil),
+			},
+			wantAllow:  true,
+			wantReason: "country not in blacklist",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			asnDB := stubReader{asnFn: func(net.IP) (*geoip2.ASN, error) {
+				return &geoip2.ASN{AutonomousSystemNumber: tc.asn}, nil
+			}}
+			anonDB := stubReader{anonymousIPFn: func(net.IP) (*geoip2.AnonymousIP, error) {
+				return &geoip2.AnonymousIP{IsAnonymous: tc.anonymous, IsTorExitNode: tc.torExit}, nil
+			}}
+
+			opts := append([]Option{WithASNDatabase(asnDB), WithAnonymousIPDatabase(anonDB)}, tc.opts...)
+			f := New(base, opts...)
+
+			decision, err := f.Allowed(net.ParseIP(egUS))
+			require.NoError(t, err)
+			assert.Equal(t, tc.wantAllow, decision.Allowed)
+			assert.Equal(t, tc.wantReason, decision.Reason)
+		})
+	}
+}
+
+// xffStrategy is a minimal fox.ClientIPStrategy that trusts the
+// X-Forwarded-For header unconditionally, used to exercise WithTrustedProxies
+// without depending on a real strategy implementation.
+type xffStrategy struct{}
+
+func (xffStrategy) ClientIP(c fox.Context) (*net.IPAddr, error) {
+	xff := c.Request().Header.Get("X-Forwarded-For")
+	if xff == "" {
+		return nil, errors.New("xffStrategy: missing X-Forwarded-For header")
+	}
+	ip := net.ParseIP(xff)
+	if ip == nil {
+		return nil, errors.New("xffStrategy: invalid X-Forwarded-For header")
+	}
+	return &net.IPAddr{IP: ip}, nil
+}
+
+func TestTrustedProxies(t *testing.T) {
+	r, err := geoip2.Open("testdata/GeoLite2-Country-outdated.mmdb")
+	require.NoError(t, err)
+
+	cases := []struct {
+		name       string
+		opts       []Option
+		remoteAddr string
+		xff        string
+		wantStatus int
+	}{
+		{
+			name: "trusted peer, spoofed country via xff is honored",
+			opts: []Option{
+				WithBlacklistedCountries("US"),
+				WithClientIPStrategy(xffStrategy{}),
+				WithTrustedProxies("10.0.0.0/8"),
+			},
+			remoteAddr: "10.0.0.1",
+			xff:        egUS,
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name: "untrusted peer, xff is ignored and remote addr is used instead",
+			opts: []Option{
+				WithBlacklistedCountries("US"),
+				WithClientIPStrategy(xffStrategy{}),
+				WithTrustedProxies("10.0.0.0/8"),
+			},
+			remoteAddr: egUS,
+			xff:        egAU,
+			wantStatus: http.StatusForbidden,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			f := fox.New(fox.WithMiddleware(Middleware(r, tc.opts...)))
+			f.MustHandle(http.MethodGet, "/foobar", func(c fox.Context) {
+				c.Writer().WriteHeader(http.StatusNoContent)
+			})
+
+			req := httptest.NewRequest(http.MethodGet, "/foobar", nil)
+			req.RemoteAddr = tc.remoteAddr
+			if tc.xff != "" {
+				req.Header.Set("X-Forwarded-For", tc.xff)
+			}
+
+			w := httptest.NewRecorder()
+			f.ServeHTTP(w, req)
+			assert.Equal(t, tc.wantStatus, w.Code)
+		})
+	}
+}
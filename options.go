@@ -7,15 +7,36 @@ package foxgeoip
 import (
 	"github.com/tigerwill90/fox"
 	"log/slog"
+	"net"
+	"time"
 )
 
 type config struct {
-	strategy     fox.ClientIPStrategy
-	handler      slog.Handler
-	blockHandler fox.HandlerFunc
-	blacklist    []string
-	whitelist    []string
-	filters      []Filter
+	strategy            fox.ClientIPStrategy
+	handler             slog.Handler
+	blockHandler        fox.HandlerFunc
+	blacklist           []string
+	whitelist           []string
+	filters             []Filter
+	cityDB              DatabaseReader
+	asnDB               DatabaseReader
+	anonymousIPDB       DatabaseReader
+	blacklistedASNs     map[uint]struct{}
+	blockAnonymous      bool
+	blockTorExit        bool
+	rules               []namedRule
+	trustedProxies      []*net.IPNet
+	trustedProxyHeaders []string
+	countryHeader       string
+	decisionHeader      string
+	stashLocation       bool
+	allowedIPBlocks     []string
+	blockedIPBlocks     []string
+	allowPrivate        bool
+	cacheSize           int
+	cacheTTL            time.Duration
+	policy              []PolicyRule
+	defaultAction       Action
 }
 
 type Option interface {
@@ -30,8 +51,9 @@ func (f optionFunc) apply(c *config) {
 
 func defaultConfig() *config {
 	return &config{
-		blockHandler: DefaultBlockingResponse,
-		handler:      noopHandler{slog.LevelDebug},
+		blockHandler:        DefaultBlockingResponse,
+		handler:             noopHandler{slog.LevelDebug},
+		trustedProxyHeaders: defaultTrustedProxyHeaders,
 	}
 }
 
@@ -93,3 +115,207 @@ func WithResponse(handler fox.HandlerFunc) Option {
 		}
 	})
 }
+
+// WithCityDatabase attaches a GeoIP2/GeoLite2 City database used to populate
+// Location.City and Location.Subdivisions. When not set, the filter falls
+// back to the database provided to New, which works as long as it supports
+// City lookups (GeoIP2/GeoLite2 City, or GeoIP2/GeoLite2 Country for the
+// country-level fields only).
+func WithCityDatabase(db DatabaseReader) Option {
+	return optionFunc(func(c *config) {
+		if db != nil {
+			c.cityDB = db
+		}
+	})
+}
+
+// WithASNDatabase attaches a GeoLite2/GeoIP2 ASN database used to populate
+// Location.ASN and Location.Org. It is required for WithBlacklistedASNs and
+// for any Rule that inspects those fields.
+func WithASNDatabase(db DatabaseReader) Option {
+	return optionFunc(func(c *config) {
+		if db != nil {
+			c.asnDB = db
+		}
+	})
+}
+
+// WithAnonymousIPDatabase attaches a GeoIP2 Anonymous IP database used to
+// populate Location.IsAnonymous, Location.IsHostingProvider and
+// Location.IsTorExit. It is required for WithBlockAnonymous, WithBlockTorExit,
+// and for any Rule that inspects those fields.
+func WithAnonymousIPDatabase(db DatabaseReader) Option {
+	return optionFunc(func(c *config) {
+		if db != nil {
+			c.anonymousIPDB = db
+		}
+	})
+}
+
+// WithBlacklistedASNs denies requests originating from the given autonomous
+// system numbers. It requires an ASN database configured with
+// WithASNDatabase.
+func WithBlacklistedASNs(asns ...uint) Option {
+	return optionFunc(func(c *config) {
+		if c.blacklistedASNs == nil {
+			c.blacklistedASNs = make(map[uint]struct{}, len(asns))
+		}
+		for _, asn := range asns {
+			c.blacklistedASNs[asn] = struct{}{}
+		}
+	})
+}
+
+// WithBlockAnonymous denies requests originating from known anonymizing
+// services (VPN, public/residential proxy, Tor). It requires an Anonymous IP
+// database configured with WithAnonymousIPDatabase.
+func WithBlockAnonymous(block bool) Option {
+	return optionFunc(func(c *config) {
+		c.blockAnonymous = block
+	})
+}
+
+// WithBlockTorExit denies requests originating from known Tor exit nodes. It
+// requires an Anonymous IP database configured with WithAnonymousIPDatabase.
+func WithBlockTorExit(block bool) Option {
+	return optionFunc(func(c *config) {
+		c.blockTorExit = block
+	})
+}
+
+// WithRule appends custom rules evaluated against each request's resolved
+// Location. A request is denied as soon as one rule matches, regardless of
+// the country blacklist/whitelist outcome. Rules are evaluated in the order
+// they were added, after the built-in ASN and anonymous-IP rules.
+func WithRule(rules ...Rule) Option {
+	return optionFunc(func(c *config) {
+		for _, r := range rules {
+			if r != nil {
+				c.rules = append(c.rules, namedRule{match: r, reason: "custom rule matched"})
+			}
+		}
+	})
+}
+
+// WithTrustedProxies sets the CIDR ranges of the reverse proxies/load
+// balancers allowed to sit in front of the application. When set, FilterIP
+// checks the immediate peer (the connection's RemoteAddr) against this list
+// before invoking the client IP strategy. If the peer is not trusted, the
+// headers configured with WithTrustedProxyHeaders are stripped from the
+// request and the peer's RemoteAddr is used as the client IP, protecting
+// against spoofed X-Forwarded-For/Forwarded headers. Invalid CIDRs are
+// ignored.
+func WithTrustedProxies(cidrs ...string) Option {
+	return optionFunc(func(c *config) {
+		for _, cidr := range cidrs {
+			_, network, err := net.ParseCIDR(cidr)
+			if err != nil {
+				continue
+			}
+			c.trustedProxies = append(c.trustedProxies, network)
+		}
+	})
+}
+
+// WithTrustedProxyHeaders overrides the set of forwarded-IP headers that are
+// stripped from a request when its peer is not in the trusted proxies list.
+// It defaults to Forwarded and X-Forwarded-For.
+func WithTrustedProxyHeaders(headers ...string) Option {
+	return optionFunc(func(c *config) {
+		c.trustedProxyHeaders = headers
+	})
+}
+
+// WithCountryHeader sets the name of a response header, e.g. "X-IPCountry",
+// populated with the resolved ISO country code on every request FilterIP
+// handles, whether allowed or blocked. This lets downstream handlers and
+// access logs see the resolved country without querying the database again.
+func WithCountryHeader(name string) Option {
+	return optionFunc(func(c *config) {
+		c.countryHeader = name
+	})
+}
+
+// WithDecisionHeader sets the name of a response header populated with
+// "allow" or "deny" on every request FilterIP handles, mirroring the
+// Decision.Allowed outcome.
+func WithDecisionHeader(name string) Option {
+	return optionFunc(func(c *config) {
+		c.decisionHeader = name
+	})
+}
+
+// WithStashLocation enables stashing the resolved Location in the request
+// context, retrievable downstream with FromContext.
+func WithStashLocation(enabled bool) Option {
+	return optionFunc(func(c *config) {
+		c.stashLocation = enabled
+	})
+}
+
+// WithAllowedIPBlocks always allows requests originating from the given CIDRs, bypassing the GeoIP lookup and
+// every other rule entirely. It is evaluated before WithBlockedIPBlocks, so it can be used to carve out an
+// exception within a blocked range, e.g. exempting health checks or office IPs from a geo-blacklist.
+func WithAllowedIPBlocks(cidrs ...string) Option {
+	return optionFunc(func(c *config) {
+		c.allowedIPBlocks = append(c.allowedIPBlocks, cidrs...)
+	})
+}
+
+// WithBlockedIPBlocks always denies requests originating from the given CIDRs, bypassing the GeoIP lookup. This
+// lets operators drop known-bad prefixes that aren't worth a country-level rule.
+func WithBlockedIPBlocks(cidrs ...string) Option {
+	return optionFunc(func(c *config) {
+		c.blockedIPBlocks = append(c.blockedIPBlocks, cidrs...)
+	})
+}
+
+// WithAllowPrivate always allows requests originating from private, loopback, or link-local addresses (see
+// privateCIDRs), bypassing the GeoIP lookup. This is useful when the filter sits behind infrastructure that may
+// forward requests from an internal network.
+func WithAllowPrivate(allow bool) Option {
+	return optionFunc(func(c *config) {
+		c.allowPrivate = allow
+	})
+}
+
+// WithLookupCache enables an LRU cache of at most size entries in front of the GeoIP database, keyed by IP, with
+// each entry reused until ttl elapses. This bounds the worst-case lookup latency and avoids re-querying the
+// database for IPs seen repeatedly at high request rates. Use IPFilter.Stats to monitor its hit rate.
+func WithLookupCache(size int, ttl time.Duration) Option {
+	return optionFunc(func(c *config) {
+		c.cacheSize = size
+		c.cacheTTL = ttl
+	})
+}
+
+// WithPolicy configures a policy list evaluated against the resolved Location of every request FilterIP allows
+// through: the first PolicyRule whose Match returns true decides the Action taken, e.g. ActionChallenge,
+// ActionRedirect, ActionTag, or ActionRateLimit, in addition to the plain ActionAllow/ActionDeny. Rules are
+// evaluated in the order they were added, across every call to WithPolicy. If no rule matches, the action set
+// with WithDefaultAction applies, or ActionAllow if none was set. This lets a single middleware express e.g.
+// "challenge CN traffic, redirect RU to a legal-notice page, allow EU, and rate-limit everyone else".
+//
+// The policy only runs for requests the rest of the filter would otherwise allow: a deny from
+// WithBlockedIPBlocks, the country blacklist/whitelist, WithBlacklistedASNs, WithBlockAnonymous,
+// WithBlockTorExit, or WithRule always wins and short-circuits before the policy is consulted, the same way those
+// checks compose with each other. Requests allowed by WithAllowedIPBlocks or WithAllowPrivate skip the policy as
+// well, since no Location is resolved for them. Combine WithPolicy with those options only when that precedence
+// is what you want.
+func WithPolicy(rules ...PolicyRule) Option {
+	return optionFunc(func(c *config) {
+		for _, rule := range rules {
+			if rule.Match != nil {
+				c.policy = append(c.policy, rule)
+			}
+		}
+	})
+}
+
+// WithDefaultAction sets the Action applied when no PolicyRule configured with WithPolicy matches a request. It
+// defaults to ActionAllow and has no effect unless WithPolicy is also used.
+func WithDefaultAction(action Action) Option {
+	return optionFunc(func(c *config) {
+		c.defaultAction = action
+	})
+}
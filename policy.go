@@ -0,0 +1,134 @@
+// Copyright 2024 Sylvain Müller. All rights reserved.
+// Mount of this source code is governed by a MIT license that can be found
+// at https://github.com/tigerwill90/foxgeoip/blob/master/LICENSE.
+
+package foxgeoip
+
+import (
+	"github.com/tigerwill90/fox"
+	"math"
+	"sync"
+	"time"
+)
+
+type actionKind uint8
+
+const (
+	actionAllow actionKind = iota
+	actionDeny
+	actionChallenge
+	actionRedirect
+	actionTag
+	actionRateLimit
+)
+
+// Action describes what FilterIP does for a request once a PolicyRule's Match matches its resolved Location, or
+// once the policy's default action applies because no rule matched. Construct one with ActionAllow, ActionDeny,
+// ActionChallenge, ActionRedirect, ActionTag, or ActionRateLimit. See WithPolicy.
+type Action struct {
+	kind    actionKind
+	handler fox.HandlerFunc
+	url     string
+	header  string
+	value   string
+	key     string
+	rps     float64
+}
+
+// ActionAllow lets the request proceed to the next handler.
+func ActionAllow() Action {
+	return Action{kind: actionAllow}
+}
+
+// ActionDeny blocks the request with the filter's configured WithResponse handler, the same response a country
+// blacklist/whitelist denial produces.
+func ActionDeny() Action {
+	return Action{kind: actionDeny}
+}
+
+// ActionChallenge routes the request to handler instead of the next handler in the chain, e.g. to serve a CAPTCHA
+// or step-up authentication prompt before letting a country or network through.
+func ActionChallenge(handler fox.HandlerFunc) Action {
+	return Action{kind: actionChallenge, handler: handler}
+}
+
+// ActionRedirect responds with a 302 Found redirect to url, e.g. to point a denylisted country at a legal notice
+// page instead of a bare 403.
+func ActionRedirect(url string) Action {
+	return Action{kind: actionRedirect, url: url}
+}
+
+// ActionTag sets the response header to value and lets the request proceed to the next handler. It is useful to
+// flag matching traffic for downstream logging or load balancing without blocking it.
+func ActionTag(header, value string) Action {
+	return Action{kind: actionTag, header: header, value: value}
+}
+
+// ActionRateLimit lets the request proceed as long as fewer than rps requests per second have been seen for key,
+// and denies it with the filter's configured WithResponse handler otherwise. key is shared across every
+// PolicyRule and request that resolves to it, so several rules can share a single bucket, e.g. to cap "everyone
+// not explicitly allowed or denied" under one limit.
+func ActionRateLimit(key string, rps float64) Action {
+	return Action{kind: actionRateLimit, key: key, rps: rps}
+}
+
+// PolicyRule pairs a Match predicate with the Action applied to requests whose resolved Location satisfies it.
+// See WithPolicy.
+type PolicyRule struct {
+	Match  Rule
+	Action Action
+}
+
+// evaluatePolicy evaluates the configured policy rules, in order, against loc and returns the Action of the
+// first match, or the filter's default action if none match.
+func (f *IPFilter) evaluatePolicy(loc Location) Action {
+	for _, rule := range f.cfg.policy {
+		if rule.Match(loc) {
+			return rule.Action
+		}
+	}
+	return f.cfg.defaultAction
+}
+
+// tokenBucket is a simple per-key token bucket used to implement ActionRateLimit without pulling in an external
+// rate limiting dependency.
+type tokenBucket struct {
+	rps    float64
+	tokens float64
+	last   time.Time
+}
+
+func (b *tokenBucket) allow(now time.Time) bool {
+	b.tokens = math.Min(math.Max(b.rps, 1), b.tokens+now.Sub(b.last).Seconds()*b.rps)
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// rateLimiters holds one tokenBucket per ActionRateLimit key, shared across all requests dispatched through a
+// given IPFilter.
+type rateLimiters struct {
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+}
+
+func newRateLimiters() *rateLimiters {
+	return &rateLimiters{buckets: make(map[string]*tokenBucket)}
+}
+
+func (l *rateLimiters) allow(key string, rps float64) bool {
+	now := time.Now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &tokenBucket{rps: rps, tokens: math.Max(rps, 1), last: now}
+		l.buckets[key] = b
+	}
+	return b.allow(now)
+}
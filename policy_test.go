@@ -0,0 +1,167 @@
+// Copyright 2024 Sylvain Müller. All rights reserved.
+// Mount of this source code is governed by a MIT license that can be found
+// at https://github.com/tigerwill90/foxgeoip/blob/master/LICENSE.
+
+package foxgeoip
+
+import (
+	"github.com/oschwald/geoip2-golang"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tigerwill90/fox"
+	"github.com/tigerwill90/fox/strategy"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestEvaluatePolicyFallthrough(t *testing.T) {
+	f := &IPFilter{
+		cfg: &config{
+			policy: []PolicyRule{
+				{Match: func(loc Location) bool { return loc.CountryISO == "CN" }, Action: ActionChallenge(nil)},
+				{Match: func(loc Location) bool { return loc.CountryISO == "RU" }, Action: ActionRedirect("https://example.com/notice")},
+				{Match: func(loc Location) bool { return loc.CountryISO == "DE" }, Action: ActionAllow()},
+			},
+			defaultAction: ActionRateLimit("default", 5),
+		},
+	}
+
+	assert.Equal(t, actionChallenge, f.evaluatePolicy(Location{CountryISO: "CN"}).kind)
+	assert.Equal(t, actionRedirect, f.evaluatePolicy(Location{CountryISO: "RU"}).kind)
+	assert.Equal(t, actionAllow, f.evaluatePolicy(Location{CountryISO: "DE"}).kind)
+
+	fallthroughAction := f.evaluatePolicy(Location{CountryISO: "FR"})
+	assert.Equal(t, actionRateLimit, fallthroughAction.kind)
+	assert.Equal(t, "default", fallthroughAction.key)
+}
+
+func TestWithPolicySkipsNilMatch(t *testing.T) {
+	cfg := defaultConfig()
+	WithPolicy(
+		PolicyRule{Action: ActionDeny()},
+		PolicyRule{Match: func(Location) bool { return true }, Action: ActionAllow()},
+	).apply(cfg)
+
+	require.Len(t, cfg.policy, 1, "a PolicyRule with a nil Match must be dropped, not stored to panic on evaluation")
+	assert.Equal(t, actionAllow, cfg.policy[0].Action.kind)
+}
+
+func TestPolicyDoesNotOverrideCIDROverrides(t *testing.T) {
+	r, err := geoip2.Open("testdata/GeoLite2-Country-outdated.mmdb")
+	require.NoError(t, err)
+
+	f := New(r,
+		WithBlockedIPBlocks("49.189.0.0/16"),
+		WithAllowedIPBlocks("52.92.0.0/16"),
+		WithPolicy(PolicyRule{Match: func(Location) bool { return false }, Action: ActionDeny()}),
+	)
+
+	decision, err := f.Allowed(net.ParseIP(egAU))
+	require.NoError(t, err)
+	assert.False(t, decision.Allowed, "blocked IP block must deny even though the policy's default action is ActionAllow")
+	assert.Equal(t, "blocked IP block", decision.Reason)
+
+	decision, err = f.Allowed(net.ParseIP(egUS))
+	require.NoError(t, err)
+	assert.True(t, decision.Allowed, "allowed IP block must allow even with an ActionDeny policy rule configured")
+	assert.Equal(t, "allowed IP block", decision.Reason)
+}
+
+func TestMiddlewareActionDispatch(t *testing.T) {
+	r, err := geoip2.Open("testdata/GeoLite2-Country-outdated.mmdb")
+	require.NoError(t, err)
+
+	f := fox.New(
+		fox.WithClientIPStrategy(strategy.NewRemoteAddr()),
+		fox.WithMiddleware(
+			Middleware(r,
+				WithPolicy(
+					PolicyRule{
+						Match: func(loc Location) bool { return loc.CountryISO == "CN" },
+						Action: ActionChallenge(func(c fox.Context) {
+							c.Writer().WriteHeader(http.StatusUnauthorized)
+						}),
+					},
+					PolicyRule{Match: func(loc Location) bool { return loc.CountryISO == "AU" }, Action: ActionChallenge(nil)},
+					PolicyRule{Match: func(loc Location) bool { return loc.CountryISO == "US" }, Action: ActionRedirect("https://example.com/notice")},
+				),
+				WithDefaultAction(ActionTag("X-Policy", "tagged")),
+			),
+		),
+	)
+	f.MustHandle(http.MethodGet, "/foobar", func(c fox.Context) {
+		c.Writer().WriteHeader(http.StatusNoContent)
+	})
+
+	cases := []struct {
+		name        string
+		remoteAddr  string
+		wantStatus  int
+		wantHeader  string
+		wantedValue string
+	}{
+		{name: "challenge runs its handler", remoteAddr: egCN, wantStatus: http.StatusUnauthorized},
+		{name: "challenge with no handler falls back to deny", remoteAddr: egAU, wantStatus: http.StatusForbidden},
+		{name: "redirect responds with 302 Found", remoteAddr: egUS, wantStatus: http.StatusFound, wantHeader: "Location", wantedValue: "https://example.com/notice"},
+		{name: "no rule matches, default action tags and allows", remoteAddr: "127.0.0.1", wantStatus: http.StatusNoContent, wantHeader: "X-Policy", wantedValue: "tagged"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/foobar", nil)
+			req.RemoteAddr = tc.remoteAddr
+			w := httptest.NewRecorder()
+
+			f.ServeHTTP(w, req)
+
+			assert.Equal(t, tc.wantStatus, w.Code)
+			if tc.wantHeader != "" {
+				assert.Equal(t, tc.wantedValue, w.Header().Get(tc.wantHeader))
+			}
+		})
+	}
+}
+
+func TestMiddlewareActionRateLimit(t *testing.T) {
+	r, err := geoip2.Open("testdata/GeoLite2-Country-outdated.mmdb")
+	require.NoError(t, err)
+
+	f := fox.New(
+		fox.WithClientIPStrategy(strategy.NewRemoteAddr()),
+		fox.WithMiddleware(
+			Middleware(r,
+				WithPolicy(PolicyRule{Match: func(Location) bool { return false }, Action: ActionDeny()}),
+				WithDefaultAction(ActionRateLimit("shared", 1)),
+			),
+		),
+	)
+	f.MustHandle(http.MethodGet, "/foobar", func(c fox.Context) {
+		c.Writer().WriteHeader(http.StatusNoContent)
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/foobar", nil)
+	req.RemoteAddr = egUS
+
+	w := httptest.NewRecorder()
+	f.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNoContent, w.Code, "first request is within the burst and should be allowed")
+
+	w = httptest.NewRecorder()
+	f.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusForbidden, w.Code, "second immediate request exceeds 1 rps and should be denied")
+}
+
+func TestRateLimitersAllow(t *testing.T) {
+	l := newRateLimiters()
+
+	assert.True(t, l.allow("a", 1))
+	assert.False(t, l.allow("a", 1))
+
+	time.Sleep(1100 * time.Millisecond)
+	assert.True(t, l.allow("a", 1))
+
+	assert.True(t, l.allow("b", 1))
+}
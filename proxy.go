@@ -0,0 +1,48 @@
+// Copyright 2024 Sylvain Müller. All rights reserved.
+// Mount of this source code is governed by a MIT license that can be found
+// at https://github.com/tigerwill90/foxgeoip/blob/master/LICENSE.
+
+package foxgeoip
+
+import (
+	"net"
+	"net/http"
+)
+
+// defaultTrustedProxyHeaders lists the header names stripped from a request
+// when its immediate peer is not in the trusted proxies list.
+var defaultTrustedProxyHeaders = []string{"Forwarded", "X-Forwarded-For"}
+
+// peerTrusted reports whether the immediate peer of r, as seen on the
+// connection's RemoteAddr, falls within the configured trusted proxies.
+func (f *IPFilter) peerTrusted(r *http.Request) bool {
+	peer := remoteAddrIP(r.RemoteAddr)
+	if peer == nil {
+		return false
+	}
+	for _, cidr := range f.cfg.trustedProxies {
+		if cidr.Contains(peer) {
+			return true
+		}
+	}
+	return false
+}
+
+// stripForwardedHeaders removes the configured trusted proxy headers from r,
+// so that neither the default client IP resolution nor a custom
+// fox.ClientIPStrategy can be fooled by a spoofed forwarded header.
+func (f *IPFilter) stripForwardedHeaders(r *http.Request) {
+	for _, header := range f.cfg.trustedProxyHeaders {
+		r.Header.Del(header)
+	}
+}
+
+// remoteAddrIP extracts the IP from a "host:port" (or bare host) RemoteAddr
+// string, returning nil if it cannot be parsed.
+func remoteAddrIP(remoteAddr string) net.IP {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	return net.ParseIP(host)
+}
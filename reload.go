@@ -0,0 +1,213 @@
+// Copyright 2024 Sylvain Müller. All rights reserved.
+// Mount of this source code is governed by a MIT license that can be found
+// at https://github.com/tigerwill90/foxgeoip/blob/master/LICENSE.
+
+package foxgeoip
+
+import (
+	"github.com/fsnotify/fsnotify"
+	"github.com/oschwald/geoip2-golang"
+	"net"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// closeGracePeriod is how long Reload waits before closing the reader it just replaced. In-flight lookups that
+// loaded the old pointer just before the swap are still running against it; geoip2 lookups are synchronous
+// mmap reads that complete in microseconds, so this window is ample headroom without requiring a full
+// reference-counting scheme around every lookup.
+const closeGracePeriod = 5 * time.Second
+
+// ReloadableReader wraps a *geoip2.Reader opened from a file on disk and atomically swaps it whenever the file
+// changes, so lookups never observe a closed reader. It implements DatabaseReader and can be passed to New,
+// Middleware, or any of the WithCityDatabase/WithASNDatabase/WithAnonymousIPDatabase options in place of a plain
+// *geoip2.Reader.
+type ReloadableReader struct {
+	path string
+	cfg  reloadConfig
+	// mu serializes Reload against itself (watch, poll, and manual callers can all race) and against Close, so
+	// that the load/open/store/schedule-close sequence below runs as one unit and the reader an in-flight
+	// Reload just opened is never leaked or closed twice.
+	mu      sync.Mutex
+	r       atomic.Pointer[geoip2.Reader]
+	watcher *fsnotify.Watcher
+	done    chan struct{}
+}
+
+type reloadConfig struct {
+	interval time.Duration
+	onReload func(old, new *geoip2.Reader, err error)
+}
+
+// ReloadOption configures a ReloadableReader created with NewReloadable.
+type ReloadOption interface {
+	apply(*reloadConfig)
+}
+
+type reloadOptionFunc func(*reloadConfig)
+
+func (f reloadOptionFunc) apply(c *reloadConfig) {
+	f(c)
+}
+
+// WithReloadInterval enables a polling fallback that reloads the database on a fixed interval, in addition to
+// the fsnotify watch. This is useful on network filesystems where file change notifications are unreliable or
+// unavailable.
+func WithReloadInterval(interval time.Duration) ReloadOption {
+	return reloadOptionFunc(func(c *reloadConfig) {
+		c.interval = interval
+	})
+}
+
+// WithOnReload sets a callback invoked after every reload attempt, whether it succeeded or failed. It is not
+// invoked for the initial load done by NewReloadable, so old is always the reader that was active before the
+// attempt. new is nil when err is non-nil, in which case that previously active reader is left in place.
+func WithOnReload(f func(old, new *geoip2.Reader, err error)) ReloadOption {
+	return reloadOptionFunc(func(c *reloadConfig) {
+		c.onReload = f
+	})
+}
+
+// NewReloadable opens the MMDB file at path and watches it for changes, atomically swapping the underlying
+// *geoip2.Reader whenever a new version opens cleanly. Call Close to stop watching and release the active
+// reader.
+func NewReloadable(path string, opts ...ReloadOption) (*ReloadableReader, error) {
+	var cfg reloadConfig
+	for _, opt := range opts {
+		opt.apply(&cfg)
+	}
+
+	db, err := geoip2.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	if err := watcher.Add(filepath.Dir(path)); err != nil {
+		_ = watcher.Close()
+		_ = db.Close()
+		return nil, err
+	}
+
+	rr := &ReloadableReader{
+		path:    path,
+		cfg:     cfg,
+		watcher: watcher,
+		done:    make(chan struct{}),
+	}
+	rr.r.Store(db)
+
+	go rr.watch()
+	if cfg.interval > 0 {
+		go rr.poll()
+	}
+
+	return rr, nil
+}
+
+func (rr *ReloadableReader) watch() {
+	name := filepath.Base(rr.path)
+	for {
+		select {
+		case <-rr.done:
+			return
+		case event, ok := <-rr.watcher.Events:
+			if !ok {
+				return
+			}
+			if filepath.Base(event.Name) != name {
+				continue
+			}
+			if event.Op&(fsnotify.Write|fsnotify.Create) == 0 {
+				continue
+			}
+			rr.Reload()
+		case _, ok := <-rr.watcher.Errors:
+			if !ok {
+				return
+			}
+		}
+	}
+}
+
+func (rr *ReloadableReader) poll() {
+	ticker := time.NewTicker(rr.cfg.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-rr.done:
+			return
+		case <-ticker.C:
+			rr.Reload()
+		}
+	}
+}
+
+// Reload reopens the MMDB file and swaps it in if it opens cleanly, leaving the currently active reader in place
+// otherwise. It is called automatically on file change and, if WithReloadInterval is set, on a timer, but may
+// also be called manually, e.g. from a SIGHUP handler.
+func (rr *ReloadableReader) Reload() {
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+
+	old := rr.r.Load()
+
+	newDB, err := geoip2.Open(rr.path)
+	if err != nil {
+		if rr.cfg.onReload != nil {
+			rr.cfg.onReload(old, nil, err)
+		}
+		return
+	}
+
+	rr.r.Store(newDB)
+	if old != nil {
+		time.AfterFunc(closeGracePeriod, func() {
+			_ = old.Close()
+		})
+	}
+
+	if rr.cfg.onReload != nil {
+		rr.cfg.onReload(old, newDB, nil)
+	}
+}
+
+// Close stops watching the database file and releases the currently active reader.
+func (rr *ReloadableReader) Close() error {
+	close(rr.done)
+	err := rr.watcher.Close()
+
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+
+	if r := rr.r.Load(); r != nil {
+		if cErr := r.Close(); err == nil {
+			err = cErr
+		}
+	}
+	return err
+}
+
+func (rr *ReloadableReader) City(ip net.IP) (*geoip2.City, error) {
+	return rr.r.Load().City(ip)
+}
+
+func (rr *ReloadableReader) Country(ip net.IP) (*geoip2.Country, error) {
+	return rr.r.Load().Country(ip)
+}
+
+func (rr *ReloadableReader) ASN(ip net.IP) (*geoip2.ASN, error) {
+	return rr.r.Load().ASN(ip)
+}
+
+func (rr *ReloadableReader) AnonymousIP(ip net.IP) (*geoip2.AnonymousIP, error) {
+	return rr.r.Load().AnonymousIP(ip)
+}
+
+var _ DatabaseReader = (*ReloadableReader)(nil)
@@ -0,0 +1,65 @@
+// Copyright 2024 Sylvain Müller. All rights reserved.
+// Mount of this source code is governed by a MIT license that can be found
+// at https://github.com/tigerwill90/foxgeoip/blob/master/LICENSE.
+
+package foxgeoip
+
+import (
+	"github.com/oschwald/geoip2-golang"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func copyFile(t *testing.T, dst, src string) {
+	t.Helper()
+	data, err := os.ReadFile(src)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(dst, data, 0o644))
+}
+
+func TestReloadableReader(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "GeoLite2-Country.mmdb")
+	copyFile(t, dbPath, "testdata/GeoLite2-Country-outdated.mmdb")
+
+	var reloaded atomic.Bool
+	rr, err := NewReloadable(dbPath, WithOnReload(func(_, _ *geoip2.Reader, err error) {
+		if err == nil {
+			reloaded.Store(true)
+		}
+	}))
+	require.NoError(t, err)
+	defer rr.Close()
+
+	country, err := rr.Country(net.ParseIP(egUS))
+	require.NoError(t, err)
+	assert.Equal(t, "US", country.Country.IsoCode)
+
+	// Touching the file with the same content still triggers a reload, swapping in a new reader.
+	copyFile(t, dbPath, "testdata/GeoLite2-Country-outdated.mmdb")
+
+	require.Eventually(t, reloaded.Load, time.Second, 10*time.Millisecond)
+
+	country, err = rr.Country(net.ParseIP(egUS))
+	require.NoError(t, err)
+	assert.Equal(t, "US", country.Country.IsoCode)
+}
+
+func TestReloadManual(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "GeoLite2-Country.mmdb")
+	copyFile(t, dbPath, "testdata/GeoLite2-Country-outdated.mmdb")
+
+	rr, err := NewReloadable(dbPath)
+	require.NoError(t, err)
+	defer rr.Close()
+
+	rr.Reload()
+
+	_, err = rr.Country(net.ParseIP(egUS))
+	require.NoError(t, err)
+}
@@ -0,0 +1,130 @@
+// Copyright 2024 Sylvain Müller. All rights reserved.
+// Mount of this source code is governed by a MIT license that can be found
+// at https://github.com/tigerwill90/foxgeoip/blob/master/LICENSE.
+
+package foxgeoip
+
+import (
+	"context"
+	"errors"
+	"github.com/oschwald/geoip2-golang"
+)
+
+// Location represents the geographic and network attributes resolved for an
+// IP address from the configured MaxMind databases. A field is left at its
+// zero value when the database required to populate it was not configured,
+// or had no data for the looked up IP.
+type Location struct {
+	// CountryISO is the ISO 3166-1 alpha-2 country code.
+	CountryISO string
+	// Subdivisions lists the ISO codes of the country subdivisions (e.g. states
+	// or provinces), ordered from least to most specific. Only populated when
+	// resolved from a City database.
+	Subdivisions []string
+	// City is the English name of the city. Only populated when resolved from
+	// a City database.
+	City string
+	// ASN is the autonomous system number, populated when an ASN database is
+	// configured with WithASNDatabase.
+	ASN uint
+	// Org is the organization associated with the ASN.
+	Org string
+	// IsAnonymous reports whether the IP is a known anonymizing service (VPN,
+	// proxy, Tor, etc), populated when an Anonymous-IP database is configured
+	// with WithAnonymousIPDatabase.
+	IsAnonymous bool
+	// IsHostingProvider reports whether the IP belongs to a hosting or
+	// colocation provider.
+	IsHostingProvider bool
+	// IsTorExit reports whether the IP is a known Tor exit node.
+	IsTorExit bool
+}
+
+// Rule is a predicate evaluated against a resolved Location. It is used with
+// WithRule to express denial conditions beyond the country blacklist/whitelist,
+// such as blocking a specific autonomous system or a class of anonymizing
+// services. A Rule must return true when the Location matches the condition
+// it checks for, which denies the request. When used as a PolicyRule's Match,
+// a true result selects that rule's Action instead.
+type Rule func(loc Location) bool
+
+// Decision is the outcome of evaluating a request's resolved Location against
+// the filter's configuration.
+type Decision struct {
+	// Allowed reports whether the request is allowed to proceed.
+	Allowed bool
+	// Location is the geolocation data resolved for the request, used to
+	// reach this Decision.
+	Location Location
+	// Reason briefly explains what rule produced the Decision. It is intended
+	// for logging and is not guaranteed to be stable across releases.
+	Reason string
+	// bypassed reports whether this Decision comes from a CIDR override (WithAllowedIPBlocks, WithBlockedIPBlocks,
+	// or WithAllowPrivate) rather than from resolving a Location, in which case Location is empty and FilterIP
+	// must honor Allowed directly instead of handing an empty Location to a configured policy.
+	bypassed bool
+}
+
+// headerValue returns the value written to the decision header: "allow" or "deny".
+func (d Decision) headerValue() string {
+	if d.Allowed {
+		return "allow"
+	}
+	return "deny"
+}
+
+// locationContextKey is the context key under which a request's resolved
+// Location is stashed when WithStashLocation is enabled.
+type locationContextKey struct{}
+
+// FromContext returns the Location resolved by FilterIP for the current
+// request. It only returns ok == true when WithStashLocation(true) was set
+// on the middleware and a Location was successfully resolved for the request.
+func FromContext(ctx context.Context) (*Location, bool) {
+	loc, ok := ctx.Value(locationContextKey{}).(*Location)
+	return loc, ok
+}
+
+// namedRule pairs a Rule with a human-readable reason so that Allowed can
+// report why a request was denied without forcing every caller of WithRule
+// to thread a reason through the Rule signature itself.
+type namedRule struct {
+	match  Rule
+	reason string
+}
+
+func blacklistedASNsRule(asns map[uint]struct{}) namedRule {
+	return namedRule{
+		reason: "blacklisted ASN",
+		match: func(loc Location) bool {
+			_, ok := asns[loc.ASN]
+			return ok
+		},
+	}
+}
+
+func blockAnonymousRule() namedRule {
+	return namedRule{
+		reason: "anonymous IP",
+		match: func(loc Location) bool {
+			return loc.IsAnonymous
+		},
+	}
+}
+
+func blockTorExitRule() namedRule {
+	return namedRule{
+		reason: "tor exit node",
+		match: func(loc Location) bool {
+			return loc.IsTorExit
+		},
+	}
+}
+
+// isInvalidMethodError reports whether err is a geoip2.InvalidMethodError,
+// which geoip2-golang returns when a lookup method is called against a
+// database that does not support it (e.g. City on a GeoIP2-Country database).
+func isInvalidMethodError(err error) bool {
+	var invalidMethodErr geoip2.InvalidMethodError
+	return errors.As(err, &invalidMethodErr)
+}